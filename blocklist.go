@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBlocklistRefreshMinutes is used when AppConfig.BlocklistRefreshMin
+// is unset or non-positive.
+const defaultBlocklistRefreshMinutes = 60
+
+// blocklistSourceStats describes the result of the last fetch of one
+// configured blocklist URL, returned from /api/blocklists/stats.
+type blocklistSourceStats struct {
+	URL       string    `json:"url"`
+	Count     int       `json:"count"`
+	LastFetch time.Time `json:"last_fetch"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+var (
+	blockedDomains  = make(map[string]struct{})
+	blocklistMutex  sync.RWMutex
+	blocklistStats  = make(map[string]blocklistSourceStats)
+	blocklistStatMu sync.Mutex
+)
+
+// isBlocked reports whether name (fully-qualified, as seen in a dns.Question)
+// is present in the merged URL-sourced blocklist.
+func isBlocked(name string) bool {
+	blocklistMutex.RLock()
+	defer blocklistMutex.RUnlock()
+	_, ok := blockedDomains[name]
+	return ok
+}
+
+// refreshBlocklists fetches every URL in urls concurrently and replaces the
+// merged blockedDomains map with the combined result.
+func refreshBlocklists(urls []string) {
+	if len(urls) == 0 {
+		blocklistMutex.Lock()
+		blockedDomains = make(map[string]struct{})
+		blocklistMutex.Unlock()
+		return
+	}
+
+	merged := make(map[string]struct{})
+	var mergeMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, url := range urls {
+		url := strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			domains, err := fetchBlocklist(url)
+
+			mergeMu.Lock()
+			for d := range domains {
+				merged[d] = struct{}{}
+			}
+			mergeMu.Unlock()
+
+			stats := blocklistSourceStats{URL: url, Count: len(domains), LastFetch: time.Now()}
+			if err != nil {
+				stats.LastError = err.Error()
+			}
+			blocklistStatMu.Lock()
+			blocklistStats[url] = stats
+			blocklistStatMu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+
+	blocklistMutex.Lock()
+	blockedDomains = merged
+	blocklistMutex.Unlock()
+}
+
+// fetchBlocklist downloads and parses a single hosts-format or domain-list
+// blocklist URL into a set of FQDNs.
+func fetchBlocklist(url string) (map[string]struct{}, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", url, resp.StatusCode)
+	}
+
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Strip hosts-file style "0.0.0.0 domain" / "127.0.0.1 domain" prefixes.
+		line = strings.TrimPrefix(line, "0.0.0.0")
+		line = strings.TrimPrefix(line, "127.0.0.1")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Hosts entries may list several hostnames on one line.
+		for _, field := range strings.Fields(line) {
+			domain := strings.ToLower(strings.TrimSpace(field))
+			if domain == "" {
+				continue
+			}
+			domains[dnsFQDN(domain)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", url, err)
+	}
+	return domains, nil
+}
+
+// dnsFQDN appends a trailing dot if domain doesn't already have one, matching
+// the form dns.Question.Name arrives in.
+func dnsFQDN(domain string) string {
+	if strings.HasSuffix(domain, ".") {
+		return domain
+	}
+	return domain + "."
+}
+
+// startBlocklistRefresher fetches all configured blocklists immediately and
+// then repeats on AppConfig.BlocklistRefreshMin, reading both the URL list
+// and the interval fresh from the active config each cycle so config edits
+// (including to the interval itself) take effect without a restart.
+func startBlocklistRefresher() {
+	refreshBlocklists(getConfig().Blocklists)
+
+	go func() {
+		for {
+			time.Sleep(blocklistRefreshInterval())
+			refreshBlocklists(getConfig().Blocklists)
+		}
+	}()
+}
+
+// blocklistRefreshInterval returns the configured refresh interval, falling
+// back to defaultBlocklistRefreshMinutes when unset.
+func blocklistRefreshInterval() time.Duration {
+	minutes := getConfig().BlocklistRefreshMin
+	if minutes <= 0 {
+		minutes = defaultBlocklistRefreshMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func blocklistStatsSnapshot() []blocklistSourceStats {
+	blocklistStatMu.Lock()
+	defer blocklistStatMu.Unlock()
+	out := make([]blocklistSourceStats, 0, len(blocklistStats))
+	for _, s := range blocklistStats {
+		out = append(out, s)
+	}
+	return out
+}
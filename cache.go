@@ -0,0 +1,220 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultCacheSize   = 1000
+	defaultCacheMinTTL = 0
+	defaultCacheMaxTTL = 3600
+)
+
+// cacheKey identifies a cached response by its question.
+type cacheKey struct {
+	qname  string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	msg      *dns.Msg
+	expires  time.Time
+	storedAt time.Time
+}
+
+// dnsCache is an LRU cache of *dns.Msg keyed by question, honoring each
+// record's TTL.
+type dnsCache struct {
+	mu      sync.Mutex
+	maxSize int
+	minTTL  int
+	maxTTL  int
+	entries map[cacheKey]*list.Element
+	order   *list.List // front = most recently used
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func newDNSCache(size, minTTL, maxTTL int) *dnsCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	if maxTTL <= 0 {
+		maxTTL = defaultCacheMaxTTL
+	}
+	if minTTL < 0 {
+		minTTL = defaultCacheMinTTL
+	}
+	return &dnsCache{
+		maxSize: size,
+		minTTL:  minTTL,
+		maxTTL:  maxTTL,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func cacheKeyFor(q dns.Question) cacheKey {
+	return cacheKey{qname: q.Name, qtype: q.Qtype, qclass: q.Qclass}
+}
+
+// get returns a copy of the cached message for q, with Id rewritten and TTLs
+// decremented by elapsed time, or nil if there is no live entry.
+func (c *dnsCache) get(q dns.Question, id uint16) *dns.Msg {
+	key := cacheKeyFor(q)
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	c.hits++
+	msg := entry.msg.Copy()
+	c.mu.Unlock()
+
+	msg.Id = id
+	elapsed := uint32(time.Since(entry.storedAt).Seconds())
+	decrementTTLs(msg, elapsed)
+	return msg
+}
+
+// set stores msg under q's key, deriving its expiration from the minimum TTL
+// across answer records (falling back to the authority SOA's MINIMUM for
+// negative responses per RFC 2308), clamped to [minTTL, maxTTL].
+func (c *dnsCache) set(q dns.Question, msg *dns.Msg) {
+	ttl := minTTLOf(msg)
+	if ttl < uint32(c.minTTL) {
+		ttl = uint32(c.minTTL)
+	}
+	if ttl > uint32(c.maxTTL) {
+		ttl = uint32(c.maxTTL)
+	}
+	if ttl == 0 {
+		return
+	}
+
+	key := cacheKeyFor(q)
+	entry := &cacheEntry{
+		key:      key,
+		msg:      msg.Copy(),
+		storedAt: time.Now(),
+		expires:  time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(entry)
+	c.entries[key] = elem
+
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *dnsCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
+
+type cacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Entries   int    `json:"entries"`
+	Evictions uint64 `json:"evictions"`
+}
+
+func (c *dnsCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Entries:   c.order.Len(),
+		Evictions: c.evictions,
+	}
+}
+
+// minTTLOf returns the smallest TTL across msg's answer records. For a
+// negative response (no answer RRs, e.g. NXDOMAIN/NODATA), it falls back to
+// the authority section's SOA MINIMUM field per RFC 2308; a positive answer
+// is never clamped by an SOA that happens to ride along in authority.
+func minTTLOf(msg *dns.Msg) uint32 {
+	var min uint32
+	found := false
+	for _, rr := range msg.Answer {
+		if t := rr.Header().Ttl; !found || t < min {
+			min, found = t, true
+		}
+	}
+	if found {
+		return min
+	}
+	for _, rr := range msg.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		if !found || soa.Minttl < min {
+			min, found = soa.Minttl, true
+		}
+	}
+	if !found {
+		return 0
+	}
+	return min
+}
+
+// decrementTTLs subtracts elapsed seconds from every RR's TTL in msg,
+// flooring at zero.
+func decrementTTLs(msg *dns.Msg, elapsed uint32) {
+	for _, rr := range msg.Answer {
+		rr.Header().Ttl = subTTL(rr.Header().Ttl, elapsed)
+	}
+	for _, rr := range msg.Ns {
+		rr.Header().Ttl = subTTL(rr.Header().Ttl, elapsed)
+	}
+	for _, rr := range msg.Extra {
+		rr.Header().Ttl = subTTL(rr.Header().Ttl, elapsed)
+	}
+}
+
+func subTTL(ttl, elapsed uint32) uint32 {
+	if elapsed >= ttl {
+		return 0
+	}
+	return ttl - elapsed
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answerMsg(qname string, ttl uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	rr, _ := dns.NewRR(fmt.Sprintf("%s %d IN A 1.2.3.4", qname, ttl))
+	m.Answer = append(m.Answer, rr)
+	return m
+}
+
+func nxdomainMsg(qname string, soaMinttl uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	m.Rcode = dns.RcodeNameError
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: qname, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: soaMinttl,
+	}
+	m.Ns = append(m.Ns, soa)
+	return m
+}
+
+func TestDNSCacheGetSetHonorsTTL(t *testing.T) {
+	c := newDNSCache(10, 0, 3600)
+	q := dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	if got := c.get(q, 1); got != nil {
+		t.Fatalf("expected miss on empty cache, got %v", got)
+	}
+
+	c.set(q, answerMsg(q.Name, 60))
+	got := c.get(q, 42)
+	if got == nil {
+		t.Fatal("expected hit after set")
+	}
+	if got.Id != 42 {
+		t.Fatalf("expected cached response Id rewritten to 42, got %d", got.Id)
+	}
+	if got.Answer[0].Header().Ttl > 60 {
+		t.Fatalf("expected decremented TTL <= 60, got %d", got.Answer[0].Header().Ttl)
+	}
+
+	stats := c.stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDNSCacheExpiresEntries(t *testing.T) {
+	c := newDNSCache(10, 0, 3600)
+	q := dns.Question{Name: "expired.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.set(q, answerMsg(q.Name, 1))
+	c.mu.Lock()
+	elem := c.entries[cacheKeyFor(q)]
+	elem.Value.(*cacheEntry).expires = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+
+	if got := c.get(q, 1); got != nil {
+		t.Fatalf("expected expired entry to miss, got %v", got)
+	}
+}
+
+func TestDNSCacheEvictsLRU(t *testing.T) {
+	c := newDNSCache(2, 0, 3600)
+	qa := dns.Question{Name: "a.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	qb := dns.Question{Name: "b.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	qc := dns.Question{Name: "c.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.set(qa, answerMsg(qa.Name, 60))
+	c.set(qb, answerMsg(qb.Name, 60))
+	c.set(qc, answerMsg(qc.Name, 60))
+
+	if got := c.get(qa, 1); got != nil {
+		t.Fatalf("expected oldest entry to be evicted, got hit")
+	}
+	if stats := c.stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats after eviction: %+v", stats)
+	}
+}
+
+func TestMinTTLOfPositiveAnswer(t *testing.T) {
+	msg := answerMsg("example.com.", 120)
+	if got := minTTLOf(msg); got != 120 {
+		t.Fatalf("minTTLOf positive answer = %d, want 120", got)
+	}
+}
+
+func TestMinTTLOfNegativeUsesSOAMinimumOnly(t *testing.T) {
+	msg := nxdomainMsg("nope.example.", 30)
+	// A low-TTL non-SOA authority record must not suppress the SOA-derived TTL.
+	msg.Ns = append(msg.Ns, &dns.NS{
+		Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 5},
+		Ns:  "ns1.example.",
+	})
+	if got := minTTLOf(msg); got != 30 {
+		t.Fatalf("minTTLOf negative response = %d, want SOA Minttl 30", got)
+	}
+}
+
+func TestMinTTLOfPositiveAnswerIgnoresAuthoritySOA(t *testing.T) {
+	msg := answerMsg("example.com.", 120)
+	// A low SOA.Minttl riding along in authority must not clamp a positive answer.
+	msg.Ns = append(msg.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: 5,
+	})
+	if got := minTTLOf(msg); got != 120 {
+		t.Fatalf("minTTLOf positive answer with authority SOA = %d, want 120", got)
+	}
+}
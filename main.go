@@ -1,13 +1,14 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -16,42 +17,130 @@ import (
 
 // Config structure for JSON communication with UI
 type AppConfig struct {
-	Port        int               `json:"port"`
-	Upstream    string            `json:"upstream"`
-	LocalDB     map[string]string `json:"local_db"`
-	Blacklist   []string          `json:"blacklist"`
+	Port                int               `json:"port"`
+	Net                 string            `json:"net"`
+	Upstreams           []string          `json:"upstreams"`
+	Strategy            string            `json:"strategy"`
+	BootstrapDNS        string            `json:"bootstrap_dns"`
+	LocalDB             map[string]string `json:"local_db"`
+	Blacklist           []string          `json:"blacklist"`
+	Blocklists          []string          `json:"blocklists"`
+	BlocklistRefreshMin int               `json:"blocklist_refresh_minutes"`
+	CacheSize           int               `json:"cache_size"`
+	CacheMinTTL         int               `json:"cache_min_ttl"`
+	CacheMaxTTL         int               `json:"cache_max_ttl"`
+	QueryLogEnabled     bool              `json:"query_log_enabled"`
+	QueryLogRetentionMB int               `json:"query_log_retention_mb"`
+	RateLimit           int               `json:"rate_limit"`
+	RefuseAny           bool              `json:"refuse_any"`
 }
 
 var (
-	currentConfig AppConfig
-	dnsServer     *dns.Server
-	configMutex   sync.Mutex
-	cancelDNS     context.CancelFunc
+	configValue atomic.Value // holds AppConfig
+	resolverPtr atomic.Pointer[Resolver]
+	cachePtr    atomic.Pointer[dnsCache]
+	queryLogPtr atomic.Pointer[QueryLog]
+	limiterPtr  atomic.Pointer[rateLimiter]
+	dnsServer   *dns.Server
+	configMutex sync.Mutex // guards dnsServer and serializes POST /api/config
 )
 
+func init() {
+	cachePtr.Store(newDNSCache(defaultCacheSize, defaultCacheMinTTL, defaultCacheMaxTTL))
+	queryLogPtr.Store(newQueryLog(true, defaultRingBufferCap, 10*1024*1024))
+	limiterPtr.Store(newRateLimiter(0))
+}
+
+// getConfig returns the active config. Safe to call from the query path
+// without taking configMutex.
+func getConfig() AppConfig {
+	return configValue.Load().(AppConfig)
+}
+
+// setConfig publishes c as the active config, picked up lock-free by the
+// next query and by any rebuild* call that follows.
+func setConfig(c AppConfig) {
+	configValue.Store(c)
+}
+
+// getResolver, getCache, getQueryLog and getLimiter are the lock-free reads
+// handleDNSRequest uses for the four pieces of state a config edit can swap
+// out; getResolver may return nil if no resolver has built successfully yet.
+func getResolver() *Resolver   { return resolverPtr.Load() }
+func getCache() *dnsCache      { return cachePtr.Load() }
+func getQueryLog() *QueryLog   { return queryLogPtr.Load() }
+func getLimiter() *rateLimiter { return limiterPtr.Load() }
+
 func loadConfig() {
-	cfg, err := ini.Load("config.ini")
+	var cfg AppConfig
+	iniFile, err := ini.Load("config.ini")
 	if err != nil {
 		log.Printf("Ini not found, using defaults")
+		cfg.Port = 5454
+		cfg.Net = "udp"
+		cfg.Upstreams = []string{"udp://8.8.8.8:53"}
+		cfg.Strategy = StrategySequential
+		cfg.CacheSize = defaultCacheSize
+		cfg.CacheMaxTTL = defaultCacheMaxTTL
+		cfg.QueryLogEnabled = true
+		cfg.QueryLogRetentionMB = 10
+		cfg.RefuseAny = true
+		cfg.BlocklistRefreshMin = defaultBlocklistRefreshMinutes
+		cfg.LocalDB = make(map[string]string)
+		setConfig(cfg)
 		return
 	}
-	currentConfig.Port = cfg.Section("server").Key("port").MustInt(5454)
-	currentConfig.Upstream = cfg.Section("server").Key("upstream").MustString("8.8.8.8:53")
-	
-	currentConfig.LocalDB = make(map[string]string)
-	for _, k := range cfg.Section("local_db").Keys() {
-		currentConfig.LocalDB[k.Name()] = k.String()
+
+	cfg.Port = iniFile.Section("server").Key("port").MustInt(5454)
+	cfg.Net = iniFile.Section("server").Key("net").MustString("udp")
+	upStr := iniFile.Section("server").Key("upstreams").MustString("udp://8.8.8.8:53")
+	cfg.Upstreams = strings.Split(upStr, ",")
+	cfg.Strategy = iniFile.Section("server").Key("strategy").MustString(StrategySequential)
+	cfg.BootstrapDNS = iniFile.Section("server").Key("bootstrap_dns").MustString("")
+	cfg.CacheSize = iniFile.Section("cache").Key("size").MustInt(defaultCacheSize)
+	cfg.CacheMinTTL = iniFile.Section("cache").Key("min_ttl").MustInt(defaultCacheMinTTL)
+	cfg.CacheMaxTTL = iniFile.Section("cache").Key("max_ttl").MustInt(defaultCacheMaxTTL)
+	cfg.QueryLogEnabled = iniFile.Section("querylog").Key("enabled").MustBool(true)
+	cfg.QueryLogRetentionMB = iniFile.Section("querylog").Key("retention_mb").MustInt(10)
+	cfg.RateLimit = iniFile.Section("server").Key("rate_limit").MustInt(0)
+	cfg.RefuseAny = iniFile.Section("server").Key("refuse_any").MustBool(true)
+
+	cfg.LocalDB = make(map[string]string)
+	for _, k := range iniFile.Section("local_db").Keys() {
+		cfg.LocalDB[k.Name()] = k.String()
+	}
+
+	blStr := iniFile.Section("blacklist").Key("domains").String()
+	cfg.Blacklist = strings.Split(blStr, ",")
+
+	blockStr := iniFile.Section("blacklist").Key("blocklist_urls").String()
+	if blockStr != "" {
+		cfg.Blocklists = strings.Split(blockStr, ",")
 	}
+	cfg.BlocklistRefreshMin = iniFile.Section("blacklist").Key("refresh_minutes").MustInt(defaultBlocklistRefreshMinutes)
 
-	blStr := cfg.Section("blacklist").Key("domains").String()
-	currentConfig.Blacklist = strings.Split(blStr, ",")
+	setConfig(cfg)
 }
 
 func saveConfigToIni(c AppConfig) {
 	cfg := ini.Empty()
 	s, _ := cfg.NewSection("server")
 	s.NewKey("port", fmt.Sprint(c.Port))
-	s.NewKey("upstream", c.Upstream)
+	s.NewKey("net", c.Net)
+	s.NewKey("upstreams", strings.Join(c.Upstreams, ","))
+	s.NewKey("strategy", c.Strategy)
+	s.NewKey("bootstrap_dns", c.BootstrapDNS)
+	s.NewKey("rate_limit", fmt.Sprint(c.RateLimit))
+	s.NewKey("refuse_any", fmt.Sprint(c.RefuseAny))
+
+	ca, _ := cfg.NewSection("cache")
+	ca.NewKey("size", fmt.Sprint(c.CacheSize))
+	ca.NewKey("min_ttl", fmt.Sprint(c.CacheMinTTL))
+	ca.NewKey("max_ttl", fmt.Sprint(c.CacheMaxTTL))
+
+	q, _ := cfg.NewSection("querylog")
+	q.NewKey("enabled", fmt.Sprint(c.QueryLogEnabled))
+	q.NewKey("retention_mb", fmt.Sprint(c.QueryLogRetentionMB))
 
 	l, _ := cfg.NewSection("local_db")
 	for k, v := range c.LocalDB {
@@ -60,56 +149,211 @@ func saveConfigToIni(c AppConfig) {
 
 	b, _ := cfg.NewSection("blacklist")
 	b.NewKey("domains", strings.Join(c.Blacklist, ","))
+	b.NewKey("blocklist_urls", strings.Join(c.Blocklists, ","))
+	b.NewKey("refresh_minutes", fmt.Sprint(c.BlocklistRefreshMin))
 	cfg.SaveTo("config.ini")
 }
 
+// rebuildResolver parses cfg.Upstreams into a fresh Resolver and swaps it in
+// atomically, leaving the previous resolver in place on error so forwarding
+// keeps working with the last-known-good set of upstreams.
+func rebuildResolver(cfg AppConfig) {
+	resolver, err := buildResolver(cfg.Upstreams, cfg.Strategy, cfg.BootstrapDNS)
+	if err != nil {
+		log.Printf("Failed to build resolver, keeping previous upstreams: %s", err)
+		return
+	}
+	resolverPtr.Store(resolver)
+}
+
+// rebuildCache swaps in a fresh cache sized per cfg, discarding any
+// previously cached entries.
+func rebuildCache(cfg AppConfig) {
+	cachePtr.Store(newDNSCache(cfg.CacheSize, cfg.CacheMinTTL, cfg.CacheMaxTTL))
+}
+
+// rebuildQueryLog swaps in a query log reflecting cfg's enabled flag and
+// retention cap, preserving no prior in-memory history. The outgoing
+// QueryLog's file is closed after the swap so its fd isn't leaked.
+func rebuildQueryLog(cfg AppConfig) {
+	retentionBytes := int64(cfg.QueryLogRetentionMB) * 1024 * 1024
+	old := queryLogPtr.Swap(newQueryLog(cfg.QueryLogEnabled, defaultRingBufferCap, retentionBytes))
+	if old != nil {
+		old.Close()
+	}
+}
+
+// rebuildRateLimiter swaps in a rate limiter for cfg.RateLimit, discarding any
+// previously tracked per-client buckets.
+func rebuildRateLimiter(cfg AppConfig) {
+	limiterPtr.Store(newRateLimiter(cfg.RateLimit))
+}
+
+// equalStrings reports whether a and b contain the same elements in the same
+// order, used to diff slice-valued config fields before deciding to rebuild.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	m := new(dns.Msg)
 	m.SetReply(r)
-	if len(r.Question) == 0 { return }
+	if len(r.Question) == 0 {
+		return
+	}
 	q := r.Question[0]
+	clientIP := clientIPFromAddr(w.RemoteAddr().String())
+
+	logResult := func(source string, rcode int, upstreamMs int64) {
+		getQueryLog().Record(QueryLogEntry{
+			Timestamp:  time.Now(),
+			ClientIP:   clientIP,
+			QName:      q.Name,
+			QType:      qtypeString(q.Qtype),
+			Source:     source,
+			UpstreamMs: upstreamMs,
+			Rcode:      rcodeString(rcode),
+		})
+	}
 
-	configMutex.Lock()
-	defer configMutex.Unlock()
+	// 1. Rate limit (checked lock-free, independent of configMutex)
+	if !getLimiter().allow(clientIP) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		logResult(SourceRefused, dns.RcodeRefused, 0)
+		return
+	}
 
-	// 1. Blacklist
-	for _, d := range currentConfig.Blacklist {
+	// 2. Blocklists (URL-sourced, checked lock-free against configMutex)
+	if isBlocked(q.Name) {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		logResult(SourceBlacklist, dns.RcodeRefused, 0)
+		return
+	}
+
+	// Config is read lock-free: queries never block a concurrent /api/config edit.
+	cfg := getConfig()
+
+	// 3. RefuseAny: ANY queries are commonly abused for reflection/amplification
+	if cfg.RefuseAny && q.Qtype == dns.TypeANY {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		logResult(SourceRefused, dns.RcodeRefused, 0)
+		return
+	}
+
+	// 4. Blacklist (static user overrides)
+	for _, d := range cfg.Blacklist {
 		if strings.TrimSpace(d) == q.Name {
 			m.SetRcode(r, dns.RcodeRefused)
 			w.WriteMsg(m)
+			logResult(SourceBlacklist, dns.RcodeRefused, 0)
 			return
 		}
 	}
 
-	// 2. Local DB
-	if ip, ok := currentConfig.LocalDB[q.Name]; ok && q.Qtype == dns.TypeA {
+	// 5. Local DB
+	if ip, ok := cfg.LocalDB[q.Name]; ok && q.Qtype == dns.TypeA {
 		rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", q.Name, ip))
 		m.Answer = append(m.Answer, rr)
 		w.WriteMsg(m)
+		logResult(SourceLocal, dns.RcodeSuccess, 0)
+		return
+	}
+
+	// 6. Cache
+	cache := getCache()
+	if cached := cache.get(q, r.Id); cached != nil {
+		w.WriteMsg(cached)
+		logResult(SourceCache, cached.Rcode, 0)
 		return
 	}
 
-	// 3. Forward
-	c := new(dns.Client)
-	c.Timeout = 2 * time.Second
-	res, _, err := c.Exchange(r, currentConfig.Upstream)
-	if err == nil { w.WriteMsg(res) }
+	// 7. Forward
+	resolver := getResolver()
+	if resolver == nil {
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+		logResult(SourceUpstream, dns.RcodeServerFailure, 0)
+		return
+	}
+	start := time.Now()
+	res, err := resolver.Exchange(r)
+	upstreamMs := time.Since(start).Milliseconds()
+	if err != nil {
+		logResult(SourceUpstream, dns.RcodeServerFailure, upstreamMs)
+		return
+	}
+	cache.set(q, res)
+	w.WriteMsg(res)
+	logResult(SourceUpstream, res.Rcode, upstreamMs)
 }
 
-func startDNSServer() {
-	configMutex.Lock()
-	addr := fmt.Sprintf(":%d", currentConfig.Port)
-	dnsServer = &dns.Server{Addr: addr, Net: "udp"}
-	configMutex.Unlock()
+const dnsStartTimeout = 3 * time.Second
 
-	log.Printf("DNS Server starting on %s", addr)
-	if err := dnsServer.ListenAndServe(); err != nil {
-		log.Printf("DNS Server stopped: %s", err)
+// bindDNSServer starts a new listener on addr/net and blocks until it has
+// definitely bound (signaled via NotifyStartedFunc) or failed, so callers get
+// an immediate, authoritative answer instead of a bind error racing by in the
+// log after the fact. Once started is reported, any later failure (e.g. an
+// OS-level socket error unrelated to a deliberate Shutdown()) is logged
+// instead of being silently dropped.
+func bindDNSServer(addr, netw string) (*dns.Server, error) {
+	server := &dns.Server{Addr: addr, Net: netw}
+	started := make(chan error, 1)
+	var reportedStart atomic.Bool
+	server.NotifyStartedFunc = func() {
+		reportedStart.Store(true)
+		started <- nil
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err == nil {
+			return
+		}
+		if reportedStart.Load() {
+			log.Printf("DNS Server stopped: %s", err)
+			return
+		}
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("DNS Server listening on %s/%s", addr, netw)
+		return server, nil
+	case <-time.After(dnsStartTimeout):
+		server.Shutdown()
+		return nil, fmt.Errorf("timed out waiting for DNS server to bind %s/%s", addr, netw)
 	}
 }
 
 func main() {
 	loadConfig()
+	cfg := getConfig()
+	rebuildResolver(cfg)
+	rebuildCache(cfg)
+	rebuildQueryLog(cfg)
+	rebuildRateLimiter(cfg)
+
+	dns.HandleFunc(".", handleDNSRequest)
+	server, err := bindDNSServer(fmt.Sprintf(":%d", cfg.Port), cfg.Net)
+	if err != nil {
+		log.Fatalf("Failed to start DNS server: %s", err)
+	}
+	dnsServer = server
 
 	// API Handlers
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -118,25 +362,97 @@ func main() {
 
 	http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			json.NewEncoder(w).Encode(currentConfig)
+			json.NewEncoder(w).Encode(getConfig())
 		} else if r.Method == "POST" {
 			var newCfg AppConfig
 			json.NewDecoder(r.Body).Decode(&newCfg)
-			
+
 			configMutex.Lock()
-			currentConfig = newCfg
+			defer configMutex.Unlock()
+
+			oldCfg := getConfig()
+			if newCfg.Port != oldCfg.Port || newCfg.Net != oldCfg.Net {
+				newServer, err := bindDNSServer(fmt.Sprintf(":%d", newCfg.Port), newCfg.Net)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Failed to bind new DNS listener: %s", err), http.StatusInternalServerError)
+					return
+				}
+				oldServer := dnsServer
+				dnsServer = newServer
+				if oldServer != nil {
+					oldServer.Shutdown()
+				}
+			}
+
+			setConfig(newCfg)
 			saveConfigToIni(newCfg)
-			if dnsServer != nil { dnsServer.Shutdown() } // Restart DNS
-			configMutex.Unlock()
 
-			go startDNSServer()
-			w.Write([]byte("Config Applied and DNS Restarted"))
+			// Only rebuild the pieces whose relevant fields actually changed, so an
+			// edit to e.g. LocalDB doesn't discard the response cache, query log
+			// history, or rate-limit buckets of unrelated subsystems.
+			if !equalStrings(newCfg.Upstreams, oldCfg.Upstreams) || newCfg.Strategy != oldCfg.Strategy || newCfg.BootstrapDNS != oldCfg.BootstrapDNS {
+				rebuildResolver(newCfg)
+			}
+			if newCfg.CacheSize != oldCfg.CacheSize || newCfg.CacheMinTTL != oldCfg.CacheMinTTL || newCfg.CacheMaxTTL != oldCfg.CacheMaxTTL {
+				rebuildCache(newCfg)
+			}
+			if newCfg.QueryLogEnabled != oldCfg.QueryLogEnabled || newCfg.QueryLogRetentionMB != oldCfg.QueryLogRetentionMB {
+				rebuildQueryLog(newCfg)
+			}
+			if newCfg.RateLimit != oldCfg.RateLimit {
+				rebuildRateLimiter(newCfg)
+			}
+
+			if !equalStrings(newCfg.Blocklists, oldCfg.Blocklists) {
+				go refreshBlocklists(newCfg.Blocklists)
+			}
+
+			w.Write([]byte("Config Applied"))
 		}
 	})
 
-	// Start DNS in background
-	dns.HandleFunc(".", handleDNSRequest)
-	go startDNSServer()
+	http.HandleFunc("/api/blocklists/reload", func(w http.ResponseWriter, r *http.Request) {
+		urls := getConfig().Blocklists
+
+		refreshBlocklists(urls)
+		json.NewEncoder(w).Encode(blocklistStatsSnapshot())
+	})
+
+	http.HandleFunc("/api/blocklists/stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(blocklistStatsSnapshot())
+	})
+
+	http.HandleFunc("/api/cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		cache := getCache()
+		cache.flush()
+		json.NewEncoder(w).Encode(cache.stats())
+	})
+
+	http.HandleFunc("/api/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(getCache().stats())
+	})
+
+	http.HandleFunc("/api/querylog", func(w http.ResponseWriter, r *http.Request) {
+		client := r.URL.Query().Get("client")
+		domain := r.URL.Query().Get("domain")
+		limit := 0
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+			limit = l
+		}
+		if limit <= 0 {
+			limit = defaultQueryLogLimit
+		}
+		json.NewEncoder(w).Encode(getQueryLog().Entries(client, domain, limit))
+	})
+
+	http.HandleFunc("/api/stats/top", func(w http.ResponseWriter, r *http.Request) {
+		kind := r.URL.Query().Get("kind")
+		window := parseWindow(r.URL.Query().Get("window"))
+		json.NewEncoder(w).Encode(getQueryLog().TopStats(kind, window))
+	})
+
+	go startBlocklistRefresher()
+	startRateLimiterSweeper()
 
 	fmt.Println("UI available at http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolution sources recorded in a QueryLogEntry.
+const (
+	SourceBlacklist = "blacklist"
+	SourceLocal     = "local"
+	SourceUpstream  = "upstream"
+	SourceCache     = "cache"
+	SourceRefused   = "refused"
+)
+
+const (
+	queryLogFile         = "querylog.json"
+	queryLogRotatedFile  = "querylog.json.1"
+	defaultQueryLogLimit = 1000
+	defaultRingBufferCap = 10000
+)
+
+// QueryLogEntry is one line of querylog.json.
+type QueryLogEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	ClientIP   string    `json:"client_ip"`
+	QName      string    `json:"qname"`
+	QType      string    `json:"qtype"`
+	Source     string    `json:"source"`
+	UpstreamMs int64     `json:"upstream_ms,omitempty"`
+	Rcode      string    `json:"rcode"`
+}
+
+// QueryLog records every handled request to a rotating JSON-lines file and
+// keeps the last entries in memory for fast UI access.
+type QueryLog struct {
+	mu          sync.Mutex
+	enabled     bool
+	ring        []QueryLogEntry
+	ringCap     int
+	ringStart   int // index of oldest entry in ring
+	ringLen     int
+	file        *os.File
+	maxFileSize int64
+}
+
+func newQueryLog(enabled bool, ringCap int, maxFileSize int64) *QueryLog {
+	if ringCap <= 0 {
+		ringCap = defaultRingBufferCap
+	}
+	ql := &QueryLog{
+		enabled:     enabled,
+		ring:        make([]QueryLogEntry, ringCap),
+		ringCap:     ringCap,
+		maxFileSize: maxFileSize,
+	}
+	if enabled {
+		ql.openFile()
+	}
+	return ql
+}
+
+func (ql *QueryLog) openFile() {
+	f, err := os.OpenFile(queryLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("QueryLog: failed to open %s: %s", queryLogFile, err)
+		return
+	}
+	ql.file = f
+}
+
+// Close closes the underlying log file, if any. Callers must call this on
+// an outgoing QueryLog once it has been replaced with a fresh one, or its fd
+// leaks until the garbage collector's finalizer reclaims it.
+func (ql *QueryLog) Close() {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if ql.file == nil {
+		return
+	}
+	ql.file.Close()
+	ql.file = nil
+}
+
+// Record appends entry to the ring buffer and, if enabled, to disk.
+func (ql *QueryLog) Record(entry QueryLogEntry) {
+	ql.mu.Lock()
+	idx := (ql.ringStart + ql.ringLen) % ql.ringCap
+	if ql.ringLen < ql.ringCap {
+		ql.ringLen++
+	} else {
+		ql.ringStart = (ql.ringStart + 1) % ql.ringCap
+	}
+	ql.ring[idx] = entry
+	enabled := ql.enabled
+	ql.mu.Unlock()
+
+	if !enabled {
+		return
+	}
+	ql.appendToFile(entry)
+}
+
+func (ql *QueryLog) appendToFile(entry QueryLogEntry) {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if ql.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	ql.file.Write(data)
+	ql.file.Write([]byte("\n"))
+
+	if info, err := ql.file.Stat(); err == nil && info.Size() > ql.maxFileSize {
+		ql.rotate()
+	}
+}
+
+// rotate renames the current log to its .1 suffix and starts a fresh file,
+// keeping exactly one generation of history on disk.
+func (ql *QueryLog) rotate() {
+	ql.file.Close()
+	os.Rename(queryLogFile, queryLogRotatedFile)
+	ql.openFile()
+}
+
+// Entries returns up to limit entries newest-first, optionally filtered by
+// client IP and/or domain (qname).
+func (ql *QueryLog) Entries(client, domain string, limit int) []QueryLogEntry {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	if limit <= 0 || limit > ql.ringLen {
+		limit = ql.ringLen
+	}
+
+	out := make([]QueryLogEntry, 0, limit)
+	for i := 0; i < ql.ringLen && len(out) < limit; i++ {
+		idx := (ql.ringStart + ql.ringLen - 1 - i) % ql.ringCap
+		e := ql.ring[idx]
+		if client != "" && e.ClientIP != client {
+			continue
+		}
+		if domain != "" && e.QName != domain {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// TopStats aggregates ring buffer entries from the last window into counts
+// of the requested kind.
+func (ql *QueryLog) TopStats(kind string, window time.Duration) map[string]int {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	counts := make(map[string]int)
+	for i := 0; i < ql.ringLen; i++ {
+		idx := (ql.ringStart + i) % ql.ringCap
+		e := ql.ring[idx]
+		if e.Timestamp.Before(cutoff) {
+			continue
+		}
+		switch kind {
+		case "domains":
+			counts[e.QName]++
+		case "clients":
+			counts[e.ClientIP]++
+		case "blocked":
+			if e.Source == SourceBlacklist {
+				counts[e.QName]++
+			}
+		}
+	}
+	return counts
+}
+
+// clientIPFromAddr extracts the bare IP from a dns.ResponseWriter's
+// RemoteAddr(), which includes the ephemeral client port.
+func clientIPFromAddr(addr string) string {
+	if host, _, err := splitHostPortSafe(addr); err == nil {
+		return strings.Trim(host, "[]")
+	}
+	return addr
+}
+
+func splitHostPortSafe(hostport string) (string, string, error) {
+	i := strings.LastIndex(hostport, ":")
+	if i < 0 {
+		return hostport, "", nil
+	}
+	return hostport[:i], hostport[i+1:], nil
+}
+
+func qtypeString(t uint16) string {
+	if name, ok := dns.TypeToString[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+func rcodeString(rcode int) string {
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+	return strconv.Itoa(rcode)
+}
+
+// parseWindow parses the "1h"-style window query param used by
+// /api/stats/top, defaulting to one hour.
+func parseWindow(raw string) time.Duration {
+	if raw == "" {
+		return time.Hour
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueryLogEntriesRingBufferWraparound(t *testing.T) {
+	ql := newQueryLog(false, 3, 0)
+	base := time.Now()
+	names := []string{"a.example.", "b.example.", "c.example.", "d.example."}
+	for i, name := range names {
+		ql.Record(QueryLogEntry{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			ClientIP:  "10.0.0.1",
+			QName:     name,
+			Source:    SourceUpstream,
+			Rcode:     "NOERROR",
+		})
+	}
+
+	// Ring cap is 3, so the oldest entry ("a.example.") must have been evicted.
+	entries := ql.Entries("", "", 0)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries after wraparound, got %d", len(entries))
+	}
+	if entries[0].QName != "d.example." {
+		t.Fatalf("expected newest-first order, got %q first", entries[0].QName)
+	}
+	for _, e := range entries {
+		if e.QName == "a.example." {
+			t.Fatalf("expected oldest entry to be evicted from the ring, found %q", e.QName)
+		}
+	}
+}
+
+func TestQueryLogEntriesFiltersByClientAndDomain(t *testing.T) {
+	ql := newQueryLog(false, 10, 0)
+	ql.Record(QueryLogEntry{Timestamp: time.Now(), ClientIP: "10.0.0.1", QName: "a.example.", Source: SourceUpstream})
+	ql.Record(QueryLogEntry{Timestamp: time.Now(), ClientIP: "10.0.0.2", QName: "b.example.", Source: SourceUpstream})
+	ql.Record(QueryLogEntry{Timestamp: time.Now(), ClientIP: "10.0.0.1", QName: "b.example.", Source: SourceUpstream})
+
+	byClient := ql.Entries("10.0.0.1", "", 0)
+	if len(byClient) != 2 {
+		t.Fatalf("expected 2 entries for client 10.0.0.1, got %d", len(byClient))
+	}
+
+	byDomain := ql.Entries("", "b.example.", 0)
+	if len(byDomain) != 2 {
+		t.Fatalf("expected 2 entries for domain b.example., got %d", len(byDomain))
+	}
+
+	byBoth := ql.Entries("10.0.0.2", "b.example.", 0)
+	if len(byBoth) != 1 {
+		t.Fatalf("expected 1 entry for client+domain filter, got %d", len(byBoth))
+	}
+}
+
+func TestQueryLogTopStatsWindowAndKind(t *testing.T) {
+	ql := newQueryLog(false, 10, 0)
+	now := time.Now()
+	ql.Record(QueryLogEntry{Timestamp: now.Add(-2 * time.Hour), ClientIP: "10.0.0.1", QName: "old.example.", Source: SourceUpstream})
+	ql.Record(QueryLogEntry{Timestamp: now, ClientIP: "10.0.0.1", QName: "recent.example.", Source: SourceUpstream})
+	ql.Record(QueryLogEntry{Timestamp: now, ClientIP: "10.0.0.2", QName: "recent.example.", Source: SourceBlacklist})
+
+	domains := ql.TopStats("domains", time.Hour)
+	if domains["recent.example."] != 2 {
+		t.Fatalf("expected 2 recent.example. queries within window, got %d", domains["recent.example."])
+	}
+	if _, ok := domains["old.example."]; ok {
+		t.Fatalf("expected old.example. to fall outside the 1h window")
+	}
+
+	blocked := ql.TopStats("blocked", time.Hour)
+	if blocked["recent.example."] != 1 {
+		t.Fatalf("expected 1 blocked recent.example., got %d", blocked["recent.example."])
+	}
+}
+
+func TestQueryLogCloseReleasesFile(t *testing.T) {
+	defer os.Remove(queryLogFile)
+	defer os.Remove(queryLogRotatedFile)
+
+	ql := newQueryLog(true, 10, 0)
+	if ql.file == nil {
+		t.Fatal("expected file to be open for an enabled QueryLog")
+	}
+	ql.Close()
+	if ql.file != nil {
+		t.Fatal("expected Close to clear the file handle")
+	}
+	// Must not panic when called again or when appending after Close.
+	ql.Close()
+	ql.Record(QueryLogEntry{Timestamp: time.Now(), QName: "a.example."})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const bucketSweepInterval = 1 * time.Minute
+
+// bucket is a token-bucket limiter for a single client IP, refilled at
+// RateLimit tokens/sec up to a burst of RateLimit tokens.
+type bucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	rate     float64
+	lastSeen time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{tokens: rate, rate: rate, lastSeen: time.Now()}
+}
+
+// allow reports whether one query may proceed, consuming a token if so.
+func (b *bucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.lastSeen = now
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *bucket) idleSince() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastSeen
+}
+
+// rateLimiter tracks one bucket per client IP, each allowing RateLimit
+// queries/sec.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	rate    float64
+}
+
+func newRateLimiter(qps int) *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket), rate: float64(qps)}
+}
+
+// allow reports whether clientIP may proceed. A rate of 0 disables limiting.
+func (rl *rateLimiter) allow(clientIP string) bool {
+	if rl.rate <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	b, ok := rl.buckets[clientIP]
+	if !ok {
+		b = newBucket(rl.rate)
+		rl.buckets[clientIP] = b
+	}
+	rl.mu.Unlock()
+
+	return b.allow()
+}
+
+// sweep evicts buckets idle for longer than maxIdle, bounding memory under
+// scans that touch many distinct client IPs.
+func (rl *rateLimiter) sweep(maxIdle time.Duration) {
+	cutoff := time.Now().Add(-maxIdle)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for ip, b := range rl.buckets {
+		if b.idleSince().Before(cutoff) {
+			delete(rl.buckets, ip)
+		}
+	}
+}
+
+// startRateLimiterSweeper periodically evicts idle buckets from the current
+// limiter, re-reading getLimiter() each tick so a config change that
+// rebuilds the limiter doesn't leave a stale one being swept.
+func startRateLimiterSweeper() {
+	go func() {
+		ticker := time.NewTicker(bucketSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			getLimiter().sweep(bucketSweepInterval)
+		}
+	}()
+}
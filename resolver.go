@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const upstreamTimeout = 2 * time.Second
+
+// Strategy values accepted in AppConfig.Strategy.
+const (
+	StrategyParallel   = "parallel"
+	StrategySequential = "sequential"
+)
+
+// Resolver forwards queries to a set of Upstreams according to a strategy.
+type Resolver struct {
+	upstreams []Upstream
+	strategy  string
+}
+
+// buildResolver parses raw upstream strings into a Resolver, resolving any
+// hostname-form DoT/DoH targets against bootstrapDNS.
+func buildResolver(rawUpstreams []string, strategy, bootstrapDNS string) (*Resolver, error) {
+	if len(rawUpstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams configured")
+	}
+	ups := make([]Upstream, 0, len(rawUpstreams))
+	for _, raw := range rawUpstreams {
+		u, err := parseUpstream(raw, bootstrapDNS, upstreamTimeout)
+		if err != nil {
+			return nil, err
+		}
+		ups = append(ups, u)
+	}
+	if strategy == "" {
+		strategy = StrategySequential
+	}
+	return &Resolver{upstreams: ups, strategy: strategy}, nil
+}
+
+// Exchange forwards r according to the Resolver's strategy.
+func (rs *Resolver) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	switch rs.strategy {
+	case StrategyParallel:
+		return rs.exchangeParallel(r)
+	default:
+		return rs.exchangeSequential(r)
+	}
+}
+
+// exchangeSequential tries each upstream in order, returning the first
+// success and falling through to the next on error.
+func (rs *Resolver) exchangeSequential(r *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, u := range rs.upstreams {
+		res, err := u.Exchange(r)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("all upstreams failed, last error: %w", lastErr)
+}
+
+// exchangeParallel fires the query at every upstream and returns the first
+// non-error response.
+func (rs *Resolver) exchangeParallel(r *dns.Msg) (*dns.Msg, error) {
+	type result struct {
+		res *dns.Msg
+		err error
+	}
+	results := make(chan result, len(rs.upstreams))
+	for _, u := range rs.upstreams {
+		go func(u Upstream) {
+			res, err := u.Exchange(r)
+			results <- result{res, err}
+		}(u)
+	}
+
+	var lastErr error
+	for i := 0; i < len(rs.upstreams); i++ {
+		rr := <-results
+		if rr.err == nil {
+			return rr.res, nil
+		}
+		lastErr = rr.err
+	}
+	return nil, fmt.Errorf("all upstreams failed, last error: %w", lastErr)
+}
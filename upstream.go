@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream resolves a DNS query against a single configured server.
+type Upstream interface {
+	// Exchange sends r and returns the response, mirroring dns.Client.Exchange.
+	Exchange(r *dns.Msg) (*dns.Msg, error)
+	// String returns the original "scheme://host" form, as accepted by the API.
+	String() string
+}
+
+const (
+	dnsMessageMIME = "application/dns-message"
+)
+
+// plainUpstream handles the udp:// and tcp:// schemes via dns.Client.
+type plainUpstream struct {
+	raw    string
+	addr   string
+	client *dns.Client
+}
+
+func newPlainUpstream(raw, addr, net string, timeout time.Duration) *plainUpstream {
+	return &plainUpstream{
+		raw:    raw,
+		addr:   addr,
+		client: &dns.Client{Net: net, Timeout: timeout},
+	}
+}
+
+func (u *plainUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	res, _, err := u.client.Exchange(r, u.addr)
+	return res, err
+}
+
+func (u *plainUpstream) String() string { return u.raw }
+
+// tlsUpstream handles the tls:// scheme (DNS-over-TLS, RFC 7858).
+type tlsUpstream struct {
+	raw    string
+	addr   string
+	client *dns.Client
+}
+
+// newTLSUpstream dials addr (a bootstrap-resolved IP:port) but pins
+// serverName as the TLS ServerName/SNI so certificate validation happens
+// against the configured hostname rather than the dialed IP literal.
+func newTLSUpstream(raw, addr, serverName string, timeout time.Duration) *tlsUpstream {
+	return &tlsUpstream{
+		raw:  raw,
+		addr: addr,
+		client: &dns.Client{
+			Net:       "tcp-tls",
+			Timeout:   timeout,
+			TLSConfig: &tls.Config{ServerName: serverName},
+		},
+	}
+}
+
+func (u *tlsUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	res, _, err := u.client.Exchange(r, u.addr)
+	return res, err
+}
+
+func (u *tlsUpstream) String() string { return u.raw }
+
+// httpsUpstream handles the https:// scheme (DNS-over-HTTPS, RFC 8484 POST).
+type httpsUpstream struct {
+	raw  string
+	url  string
+	http *http.Client
+}
+
+// newHTTPSUpstream builds a DoH upstream whose URL keeps the original
+// hostname (so the Host header and TLS ServerName/SNI stay pinned to it),
+// while its transport dials dialAddr (a bootstrap-resolved IP:port)
+// directly instead of re-resolving the hostname itself.
+func newHTTPSUpstream(raw, url, dialAddr, serverName string, timeout time.Duration) *httpsUpstream {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, network, dialAddr)
+		},
+		TLSClientConfig: &tls.Config{ServerName: serverName},
+	}
+	return &httpsUpstream{
+		raw:  raw,
+		url:  url,
+		http: &http.Client{Timeout: timeout, Transport: transport},
+	}
+}
+
+func (u *httpsUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	packed, err := r.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageMIME)
+	req.Header.Set("Accept", dnsMessageMIME)
+
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh upstream %s returned status %d", u.raw, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(dns.Msg)
+	if err := res.Unpack(body); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (u *httpsUpstream) String() string { return u.raw }
+
+// parseUpstream builds an Upstream from its "scheme://host[:port]" form,
+// resolving hostname-form DoT/DoH targets against bootstrapDNS when given.
+func parseUpstream(raw, bootstrapDNS string, timeout time.Duration) (Upstream, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		// Bare "host:port" is treated as plain UDP for backward compatibility.
+		return newPlainUpstream(raw, raw, "udp", timeout), nil
+	}
+
+	switch scheme {
+	case "udp":
+		return newPlainUpstream(raw, rest, "udp", timeout), nil
+	case "tcp":
+		return newPlainUpstream(raw, rest, "tcp", timeout), nil
+	case "tls":
+		host, port, err := splitHostPort(rest, "853")
+		if err != nil {
+			return nil, fmt.Errorf("tls upstream %q: %w", raw, err)
+		}
+		ip, err := resolveUpstreamHost(host, bootstrapDNS, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("tls upstream %q: %w", raw, err)
+		}
+		return newTLSUpstream(raw, ip+":"+port, host, timeout), nil
+	case "https":
+		hostport := rest
+		path := ""
+		if i := strings.IndexAny(hostport, "/"); i >= 0 {
+			path = hostport[i:]
+			hostport = hostport[:i]
+		}
+		if path == "" {
+			path = "/dns-query"
+		}
+		host, port, err := splitHostPort(hostport, "443")
+		if err != nil {
+			return nil, fmt.Errorf("https upstream %q: %w", raw, err)
+		}
+		ip, err := resolveUpstreamHost(host, bootstrapDNS, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("https upstream %q: %w", raw, err)
+		}
+		url := "https://" + host + ":" + port + path
+		return newHTTPSUpstream(raw, url, ip+":"+port, host, timeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme %q", scheme)
+	}
+}
+
+func splitHostPort(hostport, defaultPort string) (host, port string, err error) {
+	if i := strings.LastIndex(hostport, ":"); i >= 0 && !strings.Contains(hostport[i+1:], "]") {
+		return hostport[:i], hostport[i+1:], nil
+	}
+	if hostport == "" {
+		return "", "", fmt.Errorf("empty host")
+	}
+	return hostport, defaultPort, nil
+}
+
+// resolveUpstreamHost returns host unchanged if it is already an IP literal,
+// otherwise resolves it once via plain UDP against bootstrapDNS.
+func resolveUpstreamHost(host, bootstrapDNS string, timeout time.Duration) (string, error) {
+	if isIPLiteral(host) {
+		return host, nil
+	}
+	if bootstrapDNS == "" {
+		return "", fmt.Errorf("hostname upstream %q requires BootstrapDNS to be set", host)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+
+	c := &dns.Client{Net: "udp", Timeout: timeout}
+	res, _, err := c.Exchange(m, bootstrapDNS)
+	if err != nil {
+		return "", fmt.Errorf("bootstrap resolution of %q via %s: %w", host, bootstrapDNS, err)
+	}
+	for _, rr := range res.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			return a.A.String(), nil
+		}
+	}
+	return "", fmt.Errorf("bootstrap resolution of %q via %s returned no A record", host, bootstrapDNS)
+}
+
+func isIPLiteral(host string) bool {
+	host = strings.Trim(host, "[]")
+	for _, r := range host {
+		if r != '.' && r != ':' && (r < '0' || r > '9') && (r < 'a' || r > 'f') && (r < 'A' || r > 'F') {
+			return false
+		}
+	}
+	return strings.Count(host, ".") == 3 || strings.Contains(host, ":")
+}
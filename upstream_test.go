@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	cases := []struct {
+		name        string
+		hostport    string
+		defaultPort string
+		wantHost    string
+		wantPort    string
+		wantErr     bool
+	}{
+		{"host and port", "dns.google:853", "443", "dns.google", "853", false},
+		{"host only falls back to default", "dns.google", "853", "dns.google", "853", false},
+		{"ipv4 and port", "8.8.8.8:53", "443", "8.8.8.8", "53", false},
+		{"ipv6 literal with port", "[2001:4860:4860::8888]:53", "443", "[2001:4860:4860::8888]", "53", false},
+		{"empty host errors", "", "443", "", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host, port, err := splitHostPort(c.hostport, c.defaultPort)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("splitHostPort(%q) expected error, got host=%q port=%q", c.hostport, host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitHostPort(%q) unexpected error: %s", c.hostport, err)
+			}
+			if host != c.wantHost || port != c.wantPort {
+				t.Fatalf("splitHostPort(%q) = (%q, %q), want (%q, %q)", c.hostport, host, port, c.wantHost, c.wantPort)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamPlain(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"udp scheme", "udp://8.8.8.8:53", false},
+		{"tcp scheme", "tcp://1.1.1.1:53", false},
+		{"bare host treated as udp", "8.8.8.8:53", false},
+		{"unsupported scheme", "ftp://example.com", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u, err := parseUpstream(c.raw, "", time.Second)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseUpstream(%q) expected error", c.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUpstream(%q) unexpected error: %s", c.raw, err)
+			}
+			if u.String() != c.raw {
+				t.Fatalf("parseUpstream(%q).String() = %q, want %q", c.raw, u.String(), c.raw)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamHostnameRequiresBootstrap(t *testing.T) {
+	_, err := parseUpstream("tls://dns.google", "", time.Second)
+	if err == nil {
+		t.Fatal("expected error resolving hostname upstream without BootstrapDNS")
+	}
+}
+
+func TestParseUpstreamTLSWithIPLiteralNeedsNoBootstrap(t *testing.T) {
+	u, err := parseUpstream("tls://1.1.1.1:853", "", time.Second)
+	if err != nil {
+		t.Fatalf("parseUpstream with IP literal should not require BootstrapDNS: %s", err)
+	}
+	if u.String() != "tls://1.1.1.1:853" {
+		t.Fatalf("unexpected String() = %q", u.String())
+	}
+}
+
+func TestNewTLSUpstreamPinsServerName(t *testing.T) {
+	u := newTLSUpstream("tls://dns.quad9.net", "9.9.9.9:853", "dns.quad9.net", time.Second)
+	if u.client.TLSConfig == nil || u.client.TLSConfig.ServerName != "dns.quad9.net" {
+		t.Fatalf("expected TLS ServerName pinned to hostname, got %+v", u.client.TLSConfig)
+	}
+	if u.addr != "9.9.9.9:853" {
+		t.Fatalf("expected dial addr to stay the resolved IP:port, got %q", u.addr)
+	}
+}
+
+func TestParseUpstreamHTTPSKeepsHostnameForSNIAndHost(t *testing.T) {
+	u, err := parseUpstream("https://1.1.1.1/dns-query", "", time.Second)
+	if err != nil {
+		t.Fatalf("parseUpstream https with IP literal unexpected error: %s", err)
+	}
+	https, ok := u.(*httpsUpstream)
+	if !ok {
+		t.Fatalf("expected *httpsUpstream, got %T", u)
+	}
+	if https.url != "https://1.1.1.1:443/dns-query" {
+		t.Fatalf("unexpected url %q", https.url)
+	}
+	transport, ok := https.http.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", https.http.Transport)
+	}
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.ServerName != "1.1.1.1" {
+		t.Fatalf("expected SNI pinned to host, got %+v", transport.TLSClientConfig)
+	}
+}
+
+func TestIsIPLiteral(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":                true,
+		"1.1.1.1":                true,
+		"2001:4860:4860::8888":   true,
+		"[2001:4860:4860::8888]": true,
+		"dns.google":             false,
+		"example.com":            false,
+	}
+	for host, want := range cases {
+		if got := isIPLiteral(host); got != want {
+			t.Errorf("isIPLiteral(%q) = %v, want %v", host, got, want)
+		}
+	}
+}